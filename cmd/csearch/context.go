@@ -0,0 +1,71 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"codesearch/regexp"
+)
+
+// grepFileContext scans name for re, honoring -v (invert) and -A/-B/-C
+// (trailing/leading/both context), the way GNU grep does, printing
+// grep-style "name:line:text" output. The scan itself is the shared
+// scanLines helper; this just formats what it emits. limit caps the number
+// of matches this call will produce before it stops reading the file,
+// mirroring regexp.Grep.File's own g.Limit cutoff; pass a negative limit
+// for no cap. It returns the number of matching (non-context) lines, which
+// is what -c should report even though context lines are also written to w;
+// with -l the scan stops at the first match, so that count is at most 1.
+// groupSep is only printed when before or after context was requested.
+func grepFileContext(w io.Writer, name string, re *regexp.Regexp, g *regexp.Grep, invert bool, before, after, limit int, groupSep string) (int, error) {
+	lastPrinted := 0
+	print := func(no int, text string, sep byte) {
+		if lastPrinted != 0 && no != lastPrinted+1 && groupSep != "" && (before > 0 || after > 0) {
+			fmt.Fprintf(w, "%s\n", groupSep)
+		}
+		if g.H {
+			fmt.Fprintf(w, "%d%c%s\n", no, sep, text)
+		} else {
+			fmt.Fprintf(w, "%s%c%d%c%s\n", name, sep, no, sep, text)
+		}
+		lastPrinted = no
+	}
+
+	matchCount, err := scanLines(name, re, invert, before, after, limit, func(no int, text string, kind lineKind) bool {
+		if g.L {
+			// -l only needs to know the file has a match; stop at the first one.
+			return kind == matchKind
+		}
+		if g.C {
+			// -c wants the full count, not the lines themselves.
+			return false
+		}
+		if kind == contextKind {
+			print(no, text, '-')
+		} else {
+			print(no, text, ':')
+		}
+		return false
+	})
+	if err != nil {
+		return matchCount, err
+	}
+
+	switch {
+	case g.L:
+		if matchCount > 0 {
+			fmt.Fprintf(w, "%s\n", name)
+		}
+	case g.C:
+		if g.H {
+			fmt.Fprintf(w, "%d\n", matchCount)
+		} else {
+			fmt.Fprintf(w, "%s:%d\n", name, matchCount)
+		}
+	}
+	return matchCount, nil
+}
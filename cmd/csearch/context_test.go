@@ -0,0 +1,103 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"codesearch/regexp"
+)
+
+// TestGrepFileContextRespectsL is the regression test for chunk0-5's review
+// comment: -l must report just the file name once, the same way
+// TestGrepFileJSONRespectsL checks the --json path.
+func TestGrepFileContextRespectsL(t *testing.T) {
+	name, re := writeJSONFile(t, "one\nMATCH\nMATCH\n", "MATCH")
+	var buf bytes.Buffer
+	n, err := grepFileContext(&buf, name, re, &regexp.Grep{L: true}, false, 0, 0, -1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// -l stops scanning at the first match, so only that one is counted.
+	if n != 1 {
+		t.Fatalf("matchCount = %d, want 1", n)
+	}
+	if got := strings.TrimSpace(buf.String()); got != name {
+		t.Fatalf("-l output = %q, want just %q", got, name)
+	}
+}
+
+// TestGrepFileContextRespectsC is the regression test for chunk0-5's review
+// comment: -c must report the match count, not the matched lines, the same
+// way TestGrepFileJSONRespectsC checks the --json path.
+func TestGrepFileContextRespectsC(t *testing.T) {
+	name, re := writeJSONFile(t, "MATCH\nno\nMATCH\nMATCH\n", "MATCH")
+	var buf bytes.Buffer
+	n, err := grepFileContext(&buf, name, re, &regexp.Grep{C: true}, false, 0, 0, -1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("matchCount = %d, want 3", n)
+	}
+	want := name + ":3\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("-c output = %q, want %q", got, want)
+	}
+}
+
+// TestGrepFileContextGroupSeparator checks --group-separator: it must be
+// emitted between two context regions that aren't adjacent, and not printed
+// before the first region or when regions run together.
+func TestGrepFileContextGroupSeparator(t *testing.T) {
+	name, re := writeJSONFile(t, "a\nMATCH\nb\nc\nd\ne\nMATCH\nf\n", "MATCH")
+	var buf bytes.Buffer
+	// 1 line of context on each side: region 1 is lines 1-3, region 2 is
+	// lines 6-8, with line 4-5 excluded, so the two regions are non-adjacent
+	// and must be separated by "--".
+	if _, err := grepFileContext(&buf, name, re, &regexp.Grep{}, false, 1, 1, -1, "--"); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	if strings.Count(got, "--\n") != 1 {
+		t.Fatalf("output = %q, want exactly one group separator between the two regions", got)
+	}
+	if strings.HasPrefix(got, "--\n") {
+		t.Fatalf("output = %q, must not print a separator before the first region", got)
+	}
+}
+
+// TestGrepFileContextNoSeparatorWhenAdjacent checks that contiguous context
+// regions (no gap between them) don't get a spurious separator.
+func TestGrepFileContextNoSeparatorWhenAdjacent(t *testing.T) {
+	name, re := writeJSONFile(t, "a\nMATCH\nb\nMATCH\nc\n", "MATCH")
+	var buf bytes.Buffer
+	if _, err := grepFileContext(&buf, name, re, &regexp.Grep{}, false, 1, 1, -1, "--"); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); strings.Contains(got, "--\n") {
+		t.Fatalf("output = %q, want no group separator between adjacent matches", got)
+	}
+}
+
+// TestGrepFileContextBeforeAfter checks the plain-text -A/-B output format
+// itself: context lines use "-" as the separator, matches use ":".
+func TestGrepFileContextBeforeAfter(t *testing.T) {
+	name, re := writeJSONFile(t, "a\nb\nMATCH\nc\nd\n", "MATCH")
+	var buf bytes.Buffer
+	n, err := grepFileContext(&buf, name, re, &regexp.Grep{}, false, 1, 1, -1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("matchCount = %d, want 1", n)
+	}
+	want := name + "-2-b\n" + name + ":3:MATCH\n" + name + "-4-c\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+}
@@ -5,17 +5,23 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"runtime"
 	"runtime/pprof"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"codesearch/index"
+	"codesearch/index/filter"
 	"codesearch/regexp"
 )
 
-var usageMessage = `usage: csearch [-c] [--allow-files fileregexp] [--block-files fileregexp] [-h] [-i] [-l] [-n] regexp
+var usageMessage = `usage: csearch [-c] [--allow-files fileregexp] [--block-files fileregexp] [--files expr] [-h] [-i] [-l] [-n] regexp
 
 Csearch behaves like grep over all indexed files, searching for regexp,
 an RE2 (nearly PCRE) regular expression.
@@ -41,6 +47,42 @@ overwrites it.  Run cindex -help for more.
 
 Csearch uses the index stored in $CSEARCHINDEX or, if that variable is unset or
 empty, $HOME/.csearchindex.
+
+Csearch serve [-addr host:port] starts an HTTP daemon exposing the same
+index + regexp query pipeline over REST/JSON; run csearch serve -h for
+details.
+
+The --jobs flag controls how many files are grepped in parallel once the
+index query has narrowed down the candidate file list; it defaults to
+the number of CPUs.
+
+The --index flag names an index file to search, and may be repeated to
+search several at once; it may also name a .manifest file (see
+codesearch/index.WriteManifest for the format) listing a set of shards.
+$CSEARCHINDEX itself may be a colon-separated list of index files for the
+same purpose.
+
+The --json flag emits one JSON object per match ({"path","line","col","text"})
+instead of grep-style text, for piping into jq, editor integrations, or the
+csearch serve daemon. The -0/--null flag NUL-separates
+--only-list-candidates output instead of newline-separating it, so it
+composes with xargs -0.
+
+The -v flag inverts the match, printing non-matching lines. -A N, -B N,
+and -C N print N lines of trailing, leading, or both trailing and leading
+context around each match, the way GNU grep does; context lines do not
+count towards -c, and --group-separator (default "--", empty to disable)
+is printed between context groups that aren't adjacent in the file.
+
+The --files flag takes a boolean expression over path/ext/lang/size
+predicates (see codesearch/index/filter) selecting which candidate files
+to search, e.g. --files 'path:~\.go$ AND NOT path:vendor/ AND size:<1MB'.
+It composes with --allow-files/--block-files, which are still supported
+but are now implemented on top of the same filter.Expr evaluation. size:
+is not yet stored in the index itself, so it's resolved with a live stat
+of each candidate at query time; a file that has moved or been deleted
+since indexing silently fails to match any size: predicate rather than
+being treated as size 0.
 `
 
 func usage() {
@@ -54,6 +96,15 @@ var (
 	verboseFlag        = flag.Bool("verbose", false, "print extra information")
 	bruteFlag          = flag.Bool("brute", false, "brute force - search all files in index")
 	cpuProfile         = flag.String("cpuprofile", "", "write cpu profile to this file")
+	jobsFlag           = flag.Int("jobs", runtime.NumCPU(), "number of files to grep in parallel")
+	jsonFlag           = flag.Bool("json", false, "emit one JSON object per match instead of grep-style text")
+	nullFlag           bool
+	invertFlag         = flag.Bool("v", false, "select non-matching lines")
+	afterFlag          = flag.Int("A", 0, "print N lines of trailing context after a match")
+	beforeFlag         = flag.Int("B", 0, "print N lines of leading context before a match")
+	contextFlag        = flag.Int("C", 0, "print N lines of context around a match (both leading and trailing)")
+	groupSepFlag       = flag.String("group-separator", "--", "string printed between non-adjacent context groups; empty to disable")
+	filesFlag          string
 
 	matches bool
 )
@@ -71,8 +122,14 @@ func (i *arrayFlags) Set(value string) error {
 
 var allowFiles arrayFlags
 var blockFiles arrayFlags
+var indexFiles arrayFlags
 
 func Main() {
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		serveMain(os.Args[2:])
+		return
+	}
+
 	g := regexp.Grep{
 		Stdout: os.Stdout,
 		Stderr: os.Stderr,
@@ -80,6 +137,10 @@ func Main() {
 	g.AddFlags()
 	flag.Var(&allowFiles, "allow-files", "search only files with names matching this regexp")
 	flag.Var(&blockFiles, "block-files", "do not search files with names matching this regexp")
+	flag.Var(&indexFiles, "index", "index file or shard manifest to search; may be repeated to search several")
+	flag.StringVar(&filesFlag, "files", "", "boolean expression over path/ext/lang/size predicates selecting which candidate files to search")
+	flag.BoolVar(&nullFlag, "0", false, "NUL-separate --only-list-candidates output, for xargs -0")
+	flag.BoolVar(&nullFlag, "null", false, "alias for -0")
 
 	flag.Usage = usage
 	flag.Parse()
@@ -108,35 +169,17 @@ func Main() {
 		log.Fatal(err)
 	}
 	g.Regexp = re
-	var allowFres = make([]*regexp.Regexp, 0, len(allowFiles))
-	if len(allowFiles) > 0 {
-		for _, fFlag := range allowFiles {
-
-			var fre, err = regexp.Compile(fFlag)
-			if err != nil {
-				log.Fatal(err)
-			}
-			allowFres = append(allowFres, fre)
-		}
-	}
-	var blockFres = make([]*regexp.Regexp, 0, len(blockFiles))
-	if len(blockFiles) > 0 {
-		for _, fFlag := range blockFiles {
-
-			var fre, err = regexp.Compile(fFlag)
-			if err != nil {
-				log.Fatal(err)
-			}
-			blockFres = append(blockFres, fre)
-		}
+	fileFilter, err := fileFilterFromFlags(allowFiles, blockFiles, filesFlag)
+	if err != nil {
+		log.Fatal(err)
 	}
 	q := index.RegexpQuery(re.Syntax)
 	if *verboseFlag {
 		log.Printf("query: %s\n", q)
 	}
 
-	ix := index.Open(index.File())
-	ix.Verbose = *verboseFlag
+	ix := openShards(indexFiles)
+	ix.SetVerbose(*verboseFlag)
 	var post []uint32
 	if *bruteFlag {
 		post = ix.PostingQuery(&index.Query{Op: index.QAll})
@@ -147,50 +190,339 @@ func Main() {
 		log.Printf("post query identified %d possible files\n", len(post))
 	}
 
-	if len(allowFres) > 0 || len(blockFres) > 0 {
+	if fileFilter != nil {
 		fnames := make([]uint32, 0, len(post))
-
 		for _, fileid := range post {
 			name := ix.Name(fileid)
-			var matches = true
-			for _, fre := range allowFres {
-				if fre.MatchString(name, true, true) < 0 {
-					matches = false
-					break
+			meta := filter.FileMeta{Name: name, Size: lazyFileSize(name)}
+			if fileFilter.Match(meta) {
+				fnames = append(fnames, fileid)
+			}
+		}
+
+		if *verboseFlag {
+			log.Printf("file filter matched %d files\n", len(fnames))
+		}
+		post = fnames
+	}
+
+	before, after := *beforeFlag, *afterFlag
+	if *contextFlag > 0 {
+		if before < *contextFlag {
+			before = *contextFlag
+		}
+		if after < *contextFlag {
+			after = *contextFlag
+		}
+	}
+
+	grepPost(post, ix, &g, *onlyListCandidates, *jsonFlag, nullFlag, *invertFlag, before, after, *groupSepFlag, *jobsFlag)
+}
+
+// reserveBudget atomically claims the entire remaining g.Limit budget for
+// one file, leaving remaining at 0 until the claim is given back (in whole
+// or in part) via refundBudget. Reading remaining and later subtracting
+// from it separately (as grepPost's default case used to) lets concurrent
+// workers all load the same stale snapshot before any of them writes back,
+// so the global budget can be overshot by up to one file's worth of
+// matches per worker; claiming the whole thing atomically up front and
+// refunding what a file didn't use closes that race.
+func reserveBudget(remaining *int64) int64 {
+	for {
+		cur := atomic.LoadInt64(remaining)
+		if cur <= 0 {
+			return 0
+		}
+		if atomic.CompareAndSwapInt64(remaining, cur, 0) {
+			return cur
+		}
+	}
+}
+
+// refundBudget gives back the part of a reserveBudget claim a file didn't
+// use (a no-op if unused <= 0) and returns the resulting value of
+// remaining, so the caller can tell whether the global budget is now
+// exhausted.
+func refundBudget(remaining *int64, unused int64) int64 {
+	if unused <= 0 {
+		return atomic.LoadInt64(remaining)
+	}
+	return atomic.AddInt64(remaining, unused)
+}
+
+// grepFileResult is the output of grepping a single fileid, kept in post
+// order so the collector can flush results deterministically regardless of
+// which worker finished first.
+type grepFileResult struct {
+	idx int
+	buf *bytes.Buffer
+}
+
+// fileNamer is the only thing grepPost actually needs from an
+// *index.Shards: a fileid -> name lookup. Depending on this instead of
+// *index.Shards directly lets tests exercise the worker pool's ordering and
+// budget accounting against an in-memory fake, without needing a real
+// on-disk index.
+type fileNamer interface {
+	Name(fileid uint32) string
+}
+
+// grepPost fans the fileids in post out across jobs workers, each running
+// its own regexp.Grep against an in-memory buffer, and flushes the buffers
+// to g.Stdout in the original post order. g.Limit is tracked via a shared
+// atomic counter so the global match budget (and --only-list-candidates
+// listing) is respected the same way it would be run single-threaded. Every
+// limited branch (default, --json, -v/-A/-B/-C) reserves its own slice of
+// the remaining budget up front (reserveBudget) and caps its scan at that
+// reservation (lg.Limit, or scanLines' limit), refunding whatever it didn't
+// use (refundBudget) — reading remaining and writing it back separately,
+// as this used to do, lets concurrent workers race on the same stale
+// snapshot and collectively overshoot --limit.
+// jsonOutput and nullOutput select the --json and -0/--null output modes
+// in place of regexp.Grep's own plain-text encoding. invert, before, after,
+// and groupSep implement -v and -A/-B/-C context, also layered on top of
+// regexp.Grep rather than its own line loop, for the same reason; they
+// apply to --json output as well as plain text, since both grepFileJSON
+// and grepFileContext scan through the same scanLines helper.
+func grepPost(post []uint32, ix fileNamer, g *regexp.Grep, onlyListCandidates, jsonOutput, nullOutput, invert bool, before, after int, groupSep string, jobs int) {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	limit := int64(g.Limit)
+	var remaining int64 = -1
+	limitSet := limit > 0
+	if limitSet {
+		remaining = limit
+	}
+	var stop int32
+
+	type job struct {
+		idx    int
+		fileid uint32
+	}
+
+	jobc := make(chan job)
+	resultc := make(chan grepFileResult, jobs)
+
+	var wg sync.WaitGroup
+	wg.Add(jobs)
+	for i := 0; i < jobs; i++ {
+		go func() {
+			defer wg.Done()
+			for j := range jobc {
+				if atomic.LoadInt32(&stop) != 0 {
+					continue
 				}
+				name := ix.Name(j.fileid)
+				buf := new(bytes.Buffer)
+				switch {
+				case onlyListCandidates:
+					var reserved int64
+					if limitSet {
+						if reserved = reserveBudget(&remaining); reserved <= 0 {
+							atomic.StoreInt32(&stop, 1)
+							break
+						}
+					}
+					writeCandidateName(buf, name, nullOutput)
+					if limitSet && refundBudget(&remaining, reserved-1) <= 0 {
+						atomic.StoreInt32(&stop, 1)
+					}
+				case jsonOutput:
+					fileLimit := -1
+					var reserved int64
+					if limitSet {
+						if reserved = reserveBudget(&remaining); reserved <= 0 {
+							atomic.StoreInt32(&stop, 1)
+							break
+						}
+						fileLimit = int(reserved)
+					}
+					consumed, err := grepFileJSON(buf, name, g.Regexp, g, invert, before, after, fileLimit)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "%s: %s\n", name, err)
+					}
+					if limitSet && refundBudget(&remaining, reserved-int64(consumed)) <= 0 {
+						atomic.StoreInt32(&stop, 1)
+					}
+				case invert || before > 0 || after > 0:
+					fileLimit := -1
+					var reserved int64
+					if limitSet {
+						if reserved = reserveBudget(&remaining); reserved <= 0 {
+							atomic.StoreInt32(&stop, 1)
+							break
+						}
+						fileLimit = int(reserved)
+					}
+					consumed, err := grepFileContext(buf, name, g.Regexp, g, invert, before, after, fileLimit, groupSep)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "%s: %s\n", name, err)
+					}
+					if limitSet && refundBudget(&remaining, reserved-int64(consumed)) <= 0 {
+						atomic.StoreInt32(&stop, 1)
+					}
+				default:
+					lg := *g
+					lg.Stdout = buf
+					var reserved int64
+					if limitSet {
+						if reserved = reserveBudget(&remaining); reserved <= 0 {
+							atomic.StoreInt32(&stop, 1)
+							break
+						}
+						lg.Limit = int(reserved)
+					}
+					beforeLimit := lg.Limit
+					lg.File(name)
+					if limitSet {
+						consumed := int64(beforeLimit - lg.Limit)
+						if refundBudget(&remaining, reserved-consumed) <= 0 {
+							atomic.StoreInt32(&stop, 1)
+						}
+					}
+				}
+				resultc <- grepFileResult{idx: j.idx, buf: buf}
 			}
-			if !matches {
-				continue
+		}()
+	}
+
+	go func() {
+		defer close(jobc)
+		for i, fileid := range post {
+			if atomic.LoadInt32(&stop) != 0 {
+				return
 			}
-			for _, fre := range blockFres {
-				if fre.MatchString(name, true, true) >= 0 {
-					matches = false
-					break
-				}
+			jobc <- job{idx: i, fileid: fileid}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(resultc)
+	}()
+
+	pending := make(map[int]*bytes.Buffer)
+	next := 0
+	for res := range resultc {
+		pending[res.idx] = res.buf
+		for {
+			buf, ok := pending[next]
+			if !ok {
+				break
 			}
-			if matches {
-				fnames = append(fnames, fileid)
+			buf.WriteTo(g.Stdout)
+			delete(pending, next)
+			next++
+		}
+	}
+}
+
+// lazyFileSize returns a filter.FileMeta.Size func that stats name at most
+// once, the first time it's actually called. Nothing in the index format
+// this tree implements stores file size, so this falls back to a live
+// os.Stat; deferring and memoizing it means plain --allow-files/--block-files
+// queries (which never touch size:) don't pay for a stat per candidate, and
+// a query that does use size: only stats each file once even if the
+// expression references it more than once (e.g. "size:>1KB OR size:<10").
+func lazyFileSize(name string) func() (int64, bool) {
+	var (
+		computed bool
+		size     int64
+		ok       bool
+	)
+	return func() (int64, bool) {
+		if !computed {
+			fi, err := os.Stat(name)
+			ok = err == nil
+			if ok {
+				size = fi.Size()
 			}
+			computed = true
 		}
+		return size, ok
+	}
+}
 
-		if *verboseFlag {
-			log.Printf("filename regexp matched %d files\n", len(fnames))
+// fileFilterFromFlags compiles the legacy --allow-files (AND) / --block-files
+// (OR) regexp flags and the --files predicate expression into a single
+// filter.Expr, so all three feed the same evaluation path in Main(). It
+// returns nil if none of them were given.
+func fileFilterFromFlags(allow, block []string, filesExpr string) (filter.Expr, error) {
+	var exprs []filter.Expr
+
+	if len(allow) > 0 {
+		allowExprs := make([]filter.Expr, 0, len(allow))
+		for _, pat := range allow {
+			re, err := regexp.Compile(pat)
+			if err != nil {
+				return nil, err
+			}
+			allowExprs = append(allowExprs, filter.PathRegexp(re))
 		}
-		post = fnames
+		exprs = append(exprs, filter.And(allowExprs...))
 	}
 
-	for _, fileid := range post {
-		name := ix.Name(fileid)
-		if *onlyListCandidates {
-			fmt.Fprintf(g.Stdout, "%s\n", name)
-			g.Limit--
-		} else {
-			g.File(name)
+	if len(block) > 0 {
+		blockExprs := make([]filter.Expr, 0, len(block))
+		for _, pat := range block {
+			re, err := regexp.Compile(pat)
+			if err != nil {
+				return nil, err
+			}
+			blockExprs = append(blockExprs, filter.PathRegexp(re))
 		}
-		if g.Limit == 0 {
-			break
+		exprs = append(exprs, filter.Not(filter.Or(blockExprs...)))
+	}
+
+	if filesExpr != "" {
+		e, err := filter.Parse(filesExpr)
+		if err != nil {
+			return nil, err
 		}
+		exprs = append(exprs, e)
 	}
+
+	if len(exprs) == 0 {
+		return nil, nil
+	}
+	return filter.And(exprs...), nil
+}
+
+// openShards resolves the index file(s) to search from repeated --index
+// flags, falling back to the colon-separated $CSEARCHINDEX / ~/.csearchindex
+// default, and opens them all as a single virtual index.Shards. A path
+// ending in .manifest is expanded to the shards it lists.
+func openShards(indexFlags []string) *index.Shards {
+	var paths []string
+	if len(indexFlags) > 0 {
+		for _, f := range indexFlags {
+			paths = append(paths, strings.Split(f, ":")...)
+		}
+	} else {
+		paths = strings.Split(index.File(), ":")
+	}
+
+	var shardPaths []string
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if index.IsManifest(path) {
+			entries, err := index.ReadManifest(path)
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, e := range entries {
+				shardPaths = append(shardPaths, e.Path)
+			}
+			continue
+		}
+		shardPaths = append(shardPaths, path)
+	}
+
+	return index.OpenShards(shardPaths)
 }
 
 func main() {
@@ -0,0 +1,177 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"codesearch/regexp"
+)
+
+func TestReserveBudgetExhausts(t *testing.T) {
+	var remaining int64 = 5
+	if got := reserveBudget(&remaining); got != 5 {
+		t.Fatalf("reserveBudget = %d, want 5", got)
+	}
+	if remaining != 0 {
+		t.Fatalf("remaining after reserve = %d, want 0", remaining)
+	}
+	if got := reserveBudget(&remaining); got != 0 {
+		t.Fatalf("reserveBudget on exhausted budget = %d, want 0", got)
+	}
+}
+
+func TestRefundBudgetGivesBackUnused(t *testing.T) {
+	var remaining int64
+	if got := refundBudget(&remaining, 3); got != 3 {
+		t.Fatalf("refundBudget = %d, want 3", got)
+	}
+	if remaining != 3 {
+		t.Fatalf("remaining after refund = %d, want 3", remaining)
+	}
+	// Refunding a non-positive amount (the file used its whole reservation)
+	// must not add anything back.
+	if got := refundBudget(&remaining, 0); got != 3 {
+		t.Fatalf("refundBudget(0) = %d, want unchanged 3", got)
+	}
+}
+
+// TestReserveBudgetConcurrentNeverOverlaps is the regression test for the
+// race chunk0-2's review flagged: concurrent reservations against the same
+// budget must never let two callers believe they both claimed the same
+// units, however many goroutines race to reserve at once.
+func TestReserveBudgetConcurrentNeverOverlaps(t *testing.T) {
+	const budget = 1000
+	const workers = 20
+	var remaining int64 = budget
+
+	var wg sync.WaitGroup
+	reservations := make([]int64, workers)
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func(i int) {
+			defer wg.Done()
+			reservations[i] = reserveBudget(&remaining)
+		}(i)
+	}
+	wg.Wait()
+
+	var total int64
+	nonzero := 0
+	for _, r := range reservations {
+		total += r
+		if r > 0 {
+			nonzero++
+		}
+	}
+	if total != budget {
+		t.Fatalf("reservations sum to %d, want exactly %d (no double-counting or loss)", total, budget)
+	}
+	if nonzero != 1 {
+		t.Fatalf("%d goroutines got a non-zero reservation, want exactly 1 (the whole budget reserved atomically)", nonzero)
+	}
+}
+
+// testNamer is a fileNamer backed by an in-memory slice of paths, indexed
+// by position, so grepPost can be driven end-to-end against real files on
+// disk without needing a real on-disk *index.Shards.
+type testNamer []string
+
+func (n testNamer) Name(fileid uint32) string { return n[fileid] }
+
+// writeGrepPostFiles creates one temp file per contents string and returns
+// a testNamer plus the post list (fileids 0..n-1, in that order) grepPost
+// would receive from PostingQuery.
+func writeGrepPostFiles(t *testing.T, contents []string) (testNamer, []uint32) {
+	t.Helper()
+	dir := t.TempDir()
+	names := make(testNamer, len(contents))
+	post := make([]uint32, len(contents))
+	for i, c := range contents {
+		name := filepath.Join(dir, strings.Repeat("f", i+1)+".txt")
+		if err := os.WriteFile(name, []byte(c), 0644); err != nil {
+			t.Fatal(err)
+		}
+		names[i] = name
+		post[i] = uint32(i)
+	}
+	return names, post
+}
+
+// TestGrepPostPreservesOrderUnderJobs is the regression test for grepPost's
+// headline claim: with --jobs > 1, workers finish in whatever order the
+// scheduler gives them, but the collector must still flush each file's
+// output in the original post order.
+func TestGrepPostPreservesOrderUnderJobs(t *testing.T) {
+	// File 0 has the most matches (slowest to grep), file 4 the fewest
+	// (fastest), so a naive "flush as each worker finishes" implementation
+	// would very likely reorder these under --jobs.
+	names, post := writeGrepPostFiles(t, []string{
+		strings.Repeat("MATCH\n", 50),
+		strings.Repeat("MATCH\n", 40),
+		strings.Repeat("MATCH\n", 30),
+		strings.Repeat("MATCH\n", 20),
+		"MATCH\n",
+	})
+	re, err := regexp.Compile("MATCH")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &regexp.Grep{Regexp: re}
+	var buf bytes.Buffer
+	g.Stdout = &buf
+
+	grepPost(post, names, g, false /*onlyListCandidates*/, true /*jsonOutput*/, false, false, 0, 0, "", 8)
+
+	// --json writes one line per match, so file i's lines must all come
+	// before file i+1's.
+	var want []string
+	for i, n := range []int{50, 40, 30, 20, 1} {
+		for k := 0; k < n; k++ {
+			want = append(want, names[i])
+		}
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got %d output lines, want %d", len(lines), len(want))
+	}
+	for i, line := range lines {
+		if !strings.Contains(line, `"path":"`+want[i]+`"`) {
+			t.Fatalf("line %d = %q, want a match for %s (post order not preserved)", i, line, want[i])
+		}
+	}
+}
+
+// TestGrepPostOnlyListCandidatesRespectsLimitUnderJobs is the end-to-end
+// regression test for chunk0-2's review comment: with --jobs > several
+// workers racing ahead of each other, --only-list-candidates --limit must
+// still print exactly --limit names, not one per worker that got scheduled
+// before any of them observed the stop signal.
+func TestGrepPostOnlyListCandidatesRespectsLimitUnderJobs(t *testing.T) {
+	contents := make([]string, 20)
+	for i := range contents {
+		contents[i] = "MATCH\n"
+	}
+	names, post := writeGrepPostFiles(t, contents)
+	re, err := regexp.Compile("MATCH")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &regexp.Grep{Regexp: re, Limit: 1}
+	var buf bytes.Buffer
+	g.Stdout = &buf
+
+	grepPost(post, names, g, true /*onlyListCandidates*/, false, false, false, 0, 0, "", 8)
+
+	got := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(got) != 1 {
+		t.Fatalf("--only-list-candidates --limit 1 --jobs 8 printed %d names, want exactly 1: %v", len(got), got)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+
+	"codesearch/regexp"
+)
+
+// jsonMatch is one line of --json output: a match, or (when -v/-A/-B/-C
+// were also given) a line of context around one, suitable for piping into
+// jq, editor integrations, or the csearch serve daemon.
+type jsonMatch struct {
+	Path    string `json:"path"`
+	Line    int    `json:"line"`
+	Col     int    `json:"col"`
+	Text    string `json:"text"`
+	Context bool   `json:"context,omitempty"`
+}
+
+// jsonListMatch is the --json -l output: one line per matching file, no
+// per-line detail, matching grep's own -l semantics.
+type jsonListMatch struct {
+	Path string `json:"path"`
+}
+
+// jsonCountMatch is the --json -c output: one line per file carrying its
+// match count instead of the matches themselves.
+type jsonCountMatch struct {
+	Path  string `json:"path"`
+	Count int    `json:"count"`
+}
+
+// grepFileJSON scans name for re, writing one jsonMatch per matching or
+// context line to w as JSON instead of grep's plain "name:line:text"
+// format. invert, before, and after give it the same -v/-A/-B/-C behavior
+// as grepFileContext; both are built on the shared scanLines scan so --json
+// doesn't silently drop those flags. g.L and g.C are honored the same way
+// grepFileContext honors them: -l emits a single {"path":name} line instead
+// of per-match JSON, and -c emits a single {"path":name,"count":N} line.
+// limit caps the number of matches (not counting context lines) this call
+// will emit before it stops reading the file, mirroring regexp.Grep.File's
+// own g.Limit cutoff; pass a negative limit for no cap. It returns the
+// number of matching (non-context) lines, for g.Limit accounting; with -l
+// the scan stops at the first match, so that count is at most 1.
+func grepFileJSON(w io.Writer, name string, re *regexp.Regexp, g *regexp.Grep, invert bool, before, after, limit int) (int, error) {
+	enc := json.NewEncoder(w)
+	matchCount, err := scanLines(name, re, invert, before, after, limit, func(no int, text string, kind lineKind) bool {
+		if g.L {
+			// -l only needs to know the file has a match; stop at the first one.
+			return kind == matchKind
+		}
+		if g.C {
+			// -c wants the full count, not the lines themselves.
+			return false
+		}
+		m := jsonMatch{Path: name, Line: no, Text: text}
+		if kind == matchKind {
+			m.Col = re.MatchString(text, true, true)
+		} else {
+			m.Col = -1
+			m.Context = true
+		}
+		enc.Encode(m)
+		return false
+	})
+	if err != nil {
+		return matchCount, err
+	}
+
+	switch {
+	case g.L:
+		if matchCount > 0 {
+			enc.Encode(jsonListMatch{Path: name})
+		}
+	case g.C:
+		enc.Encode(jsonCountMatch{Path: name, Count: matchCount})
+	}
+	return matchCount, nil
+}
+
+// writeCandidateName writes name followed by the separator appropriate for
+// the current output mode: NUL when --null/-0 was given (so output
+// composes with xargs -0), newline otherwise.
+func writeCandidateName(w io.Writer, name string, nullSep bool) {
+	if nullSep {
+		io.WriteString(w, name)
+		w.Write([]byte{0})
+		return
+	}
+	io.WriteString(w, name)
+	w.Write([]byte{'\n'})
+}
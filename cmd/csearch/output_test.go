@@ -0,0 +1,92 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"codesearch/regexp"
+)
+
+func writeJSONFile(t *testing.T, contents, pattern string) (string, *regexp.Regexp) {
+	t.Helper()
+	dir := t.TempDir()
+	name := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(name, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return name, re
+}
+
+// TestGrepFileJSONRespectsL is the regression test for chunk0-4's review
+// comment: --json -l must emit a single path line per matching file instead
+// of one jsonMatch per match, the same way grepFileContext handles -l.
+func TestGrepFileJSONRespectsL(t *testing.T) {
+	name, re := writeJSONFile(t, "one\nMATCH\nMATCH\n", "MATCH")
+	var buf bytes.Buffer
+	n, err := grepFileJSON(&buf, name, re, &regexp.Grep{L: true}, false, 0, 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// -l stops scanning at the first match, so only that one is counted.
+	if n != 1 {
+		t.Fatalf("matchCount = %d, want 1", n)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("-l wrote %d lines, want 1: %q", len(lines), buf.String())
+	}
+	if !strings.Contains(lines[0], `"path":`) || strings.Contains(lines[0], `"line"`) {
+		t.Fatalf("-l output = %q, want a single path line with no per-match fields", lines[0])
+	}
+}
+
+// TestGrepFileJSONRespectsC is the regression test for chunk0-4's review
+// comment: --json -c must emit a single count line per file instead of one
+// jsonMatch per match, the same way grepFileContext handles -c.
+func TestGrepFileJSONRespectsC(t *testing.T) {
+	name, re := writeJSONFile(t, "MATCH\nno\nMATCH\nMATCH\n", "MATCH")
+	var buf bytes.Buffer
+	n, err := grepFileJSON(&buf, name, re, &regexp.Grep{C: true}, false, 0, 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("matchCount = %d, want 3", n)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("-c wrote %d lines, want 1: %q", len(lines), buf.String())
+	}
+	if !strings.Contains(lines[0], `"count":3`) {
+		t.Fatalf("-c output = %q, want it to carry count:3", lines[0])
+	}
+}
+
+// TestGrepFileJSONPlain makes sure the default (no -l/-c) path is
+// unaffected: every match still becomes its own jsonMatch line.
+func TestGrepFileJSONPlain(t *testing.T) {
+	name, re := writeJSONFile(t, "one\nMATCH\ntwo\n", "MATCH")
+	var buf bytes.Buffer
+	n, err := grepFileJSON(&buf, name, re, &regexp.Grep{}, false, 0, 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("matchCount = %d, want 1", n)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], `"line":2`) {
+		t.Fatalf("plain output = %q, want one jsonMatch line for line 2", buf.String())
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"os"
+
+	"codesearch/regexp"
+)
+
+// lineKind distinguishes a matching line from a context line in scanLines'
+// emit callback, so callers that care (like --json) can tell them apart
+// without re-running the regexp themselves.
+type lineKind int
+
+const (
+	matchKind lineKind = iota
+	contextKind
+)
+
+// contextLine is one line held in the leading-context ring buffer, waiting
+// to find out whether it precedes a match closely enough to be emitted.
+type contextLine struct {
+	no   int
+	text string
+}
+
+// scanLines is the single line-by-line scan that both grepFileContext and
+// grepFileJSON are built on, so -v/-A/-B/-C behave identically regardless
+// of output format (text or --json) instead of each format reimplementing
+// its own copy of the ring-buffer logic. It streams rather than buffering
+// the whole file: leading context is held in a ring buffer sized `before`,
+// and trailing context is tracked with a simple countdown, so memory use
+// stays O(before+after) regardless of file size.
+//
+// emit is called for every line that should be surfaced, tagged matchKind
+// or contextKind. If emit returns true, scanning stops immediately (used by
+// -l, which only needs to know a file has at least one match). scanLines
+// returns the number of matching (non-context) lines, which is what -c
+// should report even when emit chooses not to print anything for them.
+//
+// limit caps how many matching lines this call will produce, the same
+// budget g.Limit gives regexp.Grep.File: once that many matches have been
+// emitted, scanLines stops reading the rest of the file instead of
+// emitting every match and letting the caller discard the overage after
+// the fact. Pass a negative limit for no cap.
+func scanLines(name string, re *regexp.Regexp, invert bool, before, after, limit int, emit func(no int, text string, kind lineKind) (stop bool)) (int, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	ring := make([]contextLine, 0, before)
+	afterRemaining := 0
+	matchCount := 0
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	lineno := 0
+	for sc.Scan() {
+		lineno++
+		line := sc.Text()
+		isMatch := re.MatchString(line, true, true) >= 0
+		if invert {
+			isMatch = !isMatch
+		}
+
+		switch {
+		case isMatch:
+			matchCount++
+			for _, cl := range ring {
+				if emit(cl.no, cl.text, contextKind) {
+					return matchCount, sc.Err()
+				}
+			}
+			ring = ring[:0]
+			if emit(lineno, line, matchKind) {
+				return matchCount, sc.Err()
+			}
+			if limit >= 0 && matchCount >= limit {
+				return matchCount, sc.Err()
+			}
+			afterRemaining = after
+
+		case afterRemaining > 0:
+			if emit(lineno, line, contextKind) {
+				return matchCount, sc.Err()
+			}
+			afterRemaining--
+
+		case before > 0:
+			ring = append(ring, contextLine{lineno, line})
+			if len(ring) > before {
+				ring = ring[1:]
+			}
+		}
+	}
+	return matchCount, sc.Err()
+}
@@ -0,0 +1,122 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"codesearch/regexp"
+)
+
+type scanResult struct {
+	no   int
+	text string
+	kind lineKind
+}
+
+func scanFile(t *testing.T, contents, pattern string, invert bool, before, after int) (int, []scanResult) {
+	t.Helper()
+	return scanFileWithLimit(t, contents, pattern, invert, before, after, -1)
+}
+
+func scanFileWithLimit(t *testing.T, contents, pattern string, invert bool, before, after, limit int) (int, []scanResult) {
+	t.Helper()
+	dir := t.TempDir()
+	name := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(name, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []scanResult
+	n, err := scanLines(name, re, invert, before, after, limit, func(no int, text string, kind lineKind) bool {
+		got = append(got, scanResult{no, text, kind})
+		return false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n, got
+}
+
+func TestScanLinesNoContext(t *testing.T) {
+	n, got := scanFile(t, "one\ntwo\nthree\n", "two", false, 0, 0)
+	if n != 1 {
+		t.Fatalf("matchCount = %d, want 1", n)
+	}
+	if len(got) != 1 || got[0].no != 2 || got[0].kind != matchKind {
+		t.Fatalf("got %+v, want single match at line 2", got)
+	}
+}
+
+func TestScanLinesBeforeAfter(t *testing.T) {
+	n, got := scanFile(t, "a\nb\nMATCH\nc\nd\n", "MATCH", false, 1, 1)
+	if n != 1 {
+		t.Fatalf("matchCount = %d, want 1", n)
+	}
+	want := []scanResult{
+		{2, "b", contextKind},
+		{3, "MATCH", matchKind},
+		{4, "c", contextKind},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestScanLinesInvert(t *testing.T) {
+	n, got := scanFile(t, "match\nno\nmatch\n", "match", true, 0, 0)
+	if n != 1 {
+		t.Fatalf("matchCount = %d, want 1", n)
+	}
+	if len(got) != 1 || got[0].no != 2 || got[0].text != "no" {
+		t.Fatalf("got %+v, want only line 2 (the non-matching line)", got)
+	}
+}
+
+func TestScanLinesStopsEarly(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(name, []byte("a\nMATCH\nb\nMATCH\nc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	re, err := regexp.Compile("MATCH")
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	n, err := scanLines(name, re, false, 0, 0, -1, func(no int, text string, kind lineKind) bool {
+		calls++
+		return true // stop after the first emitted line, like -l does
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Fatalf("emit called %d times, want 1", calls)
+	}
+	if n != 1 {
+		t.Fatalf("matchCount = %d, want 1 (scan stopped before the second match)", n)
+	}
+}
+
+func TestScanLinesRespectsLimit(t *testing.T) {
+	n, got := scanFileWithLimit(t, "MATCH\nMATCH\nMATCH\nMATCH\n", "MATCH", false, 0, 0, 2)
+	if n != 2 {
+		t.Fatalf("matchCount = %d, want 2 (capped by limit)", n)
+	}
+	if len(got) != 2 {
+		t.Fatalf("emit called %d times, want 2; limit should stop the scan instead of emitting every match", len(got))
+	}
+}
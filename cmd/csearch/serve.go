@@ -0,0 +1,407 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"codesearch/index"
+	"codesearch/regexp"
+)
+
+// serveUsageMessage documents the csearch serve subcommand, which exposes
+// the same index + regexp query pipeline used by Main() over HTTP/JSON so
+// that editors, bots, and web UIs can drive it without shelling out to
+// csearch for every query.
+var serveUsageMessage = `usage: csearch serve [-addr host:port] [-query-timeout dur]
+
+Serve starts an HTTP daemon that answers:
+
+	GET /search?q=<re>&allow=<re>&block=<re>&limit=N&context=N
+	GET /file?name=path&hl=<re>
+
+against the index named by $CSEARCHINDEX or -index.
+`
+
+func serveUsage() {
+	fmt.Fprintf(os.Stderr, serveUsageMessage)
+	os.Exit(2)
+}
+
+// server holds the single shared index handle used to answer queries. The
+// index file is reopened whenever its mtime changes so a cindex run while
+// the daemon is up doesn't require a restart.
+type server struct {
+	mu           sync.RWMutex
+	path         string
+	ix           *index.Index
+	ixModTime    time.Time
+	queryTimeout time.Duration
+	names        map[string]bool // set of file names known to ix, for handleFile
+}
+
+func newServer(path string, queryTimeout time.Duration) *server {
+	s := &server{path: path, queryTimeout: queryTimeout}
+	s.reload()
+	return s
+}
+
+// reload reopens the index if the underlying file has changed since it was
+// last opened, and is safe to call before every request.
+func (s *server) reload() *index.Index {
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		log.Printf("serve: stat %s: %v", s.path, err)
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return s.ix
+	}
+
+	s.mu.RLock()
+	stale := s.ix == nil || fi.ModTime().After(s.ixModTime)
+	s.mu.RUnlock()
+	if !stale {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return s.ix
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ix := index.Open(s.path)
+	s.ix = ix
+	s.ixModTime = fi.ModTime()
+	s.names = indexedNames(ix)
+	return s.ix
+}
+
+// indexedNames returns the set of every file name ix knows about, so
+// handleFile can check a requested name is actually indexed before opening
+// it off the filesystem.
+func indexedNames(ix *index.Index) map[string]bool {
+	names := make(map[string]bool)
+	for _, fileid := range ix.PostingQuery(&index.Query{Op: index.QAll}) {
+		names[ix.Name(fileid)] = true
+	}
+	return names
+}
+
+// isIndexed reports whether name is a file known to the currently loaded
+// index.
+func (s *server) isIndexed(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.names[name]
+}
+
+type matchResult struct {
+	Line   int      `json:"line"`
+	Col    int      `json:"col"`
+	Text   string   `json:"text"`
+	Before []string `json:"before,omitempty"`
+	After  []string `json:"after,omitempty"`
+}
+
+type fileResult struct {
+	Name    string        `json:"name"`
+	Matches []matchResult `json:"matches"`
+}
+
+type searchStats struct {
+	FilesConsidered int   `json:"filesConsidered"`
+	FilesSearched   int   `json:"filesSearched"`
+	PostingMs       int64 `json:"postingMs"`
+	GrepMs          int64 `json:"grepMs"`
+}
+
+type searchResponse struct {
+	Files []fileResult `json:"files"`
+	Stats searchStats  `json:"stats"`
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+// handleSearch answers GET /search?q=<re>&allow=<re>&block=<re>&limit=N&context=N
+// by running the same PostingQuery pipeline Main() uses, bounded by a
+// context deadline so a pathological regexp can't block the server.
+func (s *server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	pat := q.Get("q")
+	if pat == "" {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("missing q parameter"))
+		return
+	}
+	limit := 0
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("bad limit: %v", err))
+			return
+		}
+		limit = n
+	}
+	ctxLines := 0
+	if v := q.Get("context"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("bad context: %v", err))
+			return
+		}
+		ctxLines = n
+	}
+
+	ctx := r.Context()
+	if s.queryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.queryTimeout)
+		defer cancel()
+	}
+
+	// Compiling the pattern and running PostingQuery both run the actual
+	// regexp/posting-list machinery a pathological query could make slow,
+	// so they run on their own goroutine and race against ctx here rather
+	// than being called inline - otherwise only the later per-file grep
+	// loop was deadline-bound, and a bad query could still hang the
+	// handler (and, since net/http runs one goroutine per request but this
+	// one never gave up its connection, tie up the server) before that
+	// loop was ever reached.
+	type queryResult struct {
+		re, allowRe, blockRe *regexp.Regexp
+		ix                   *index.Index
+		post                 []uint32
+		postingMs            int64
+		err                  error
+		status               int
+	}
+	resultc := make(chan queryResult, 1)
+	go func() {
+		re, err := regexp.Compile("(?m)" + pat)
+		if err != nil {
+			resultc <- queryResult{err: err, status: http.StatusBadRequest}
+			return
+		}
+		var allowRe, blockRe *regexp.Regexp
+		if v := q.Get("allow"); v != "" {
+			allowRe, err = regexp.Compile(v)
+			if err != nil {
+				resultc <- queryResult{err: err, status: http.StatusBadRequest}
+				return
+			}
+		}
+		if v := q.Get("block"); v != "" {
+			blockRe, err = regexp.Compile(v)
+			if err != nil {
+				resultc <- queryResult{err: err, status: http.StatusBadRequest}
+				return
+			}
+		}
+
+		ix := s.reload()
+		if ix == nil {
+			resultc <- queryResult{err: fmt.Errorf("index not available"), status: http.StatusServiceUnavailable}
+			return
+		}
+
+		postStart := time.Now()
+		post := ix.PostingQuery(index.RegexpQuery(re.Syntax))
+		resultc <- queryResult{
+			re: re, allowRe: allowRe, blockRe: blockRe, ix: ix,
+			post: post, postingMs: time.Since(postStart).Milliseconds(),
+		}
+	}()
+
+	var qr queryResult
+	select {
+	case <-ctx.Done():
+		writeJSONError(w, http.StatusGatewayTimeout, ctx.Err())
+		return
+	case qr = <-resultc:
+		if qr.err != nil {
+			writeJSONError(w, qr.status, qr.err)
+			return
+		}
+	}
+	// Reuse the exact *index.Index the goroutine above queried, rather than
+	// calling s.reload() again here: a concurrent cindex run could swap s.ix
+	// between the two calls, leaving post's fileids resolved against a
+	// different generation of the index than the one that produced them.
+	re, allowRe, blockRe, post, ix := qr.re, qr.allowRe, qr.blockRe, qr.post, qr.ix
+
+	resp := searchResponse{Stats: searchStats{FilesConsidered: len(post), PostingMs: qr.postingMs}}
+
+	grepStart := time.Now()
+	for _, fileid := range post {
+		select {
+		case <-ctx.Done():
+			writeJSONError(w, http.StatusGatewayTimeout, ctx.Err())
+			return
+		default:
+		}
+
+		name := ix.Name(fileid)
+		if allowRe != nil && allowRe.MatchString(name, true, true) < 0 {
+			continue
+		}
+		if blockRe != nil && blockRe.MatchString(name, true, true) >= 0 {
+			continue
+		}
+
+		resp.Stats.FilesSearched++
+		matches, err := grepFile(name, re, ctxLines)
+		if err != nil {
+			continue
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		resp.Files = append(resp.Files, fileResult{Name: name, Matches: matches})
+
+		if limit > 0 && len(resp.Files) >= limit {
+			break
+		}
+	}
+	resp.Stats.GrepMs = time.Since(grepStart).Milliseconds()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// grepFile scans name line by line for re, returning each match along with
+// up to ctxLines of surrounding context. It is independent of regexp.Grep's
+// stdout-oriented printing so matches can be collected as structured data.
+//
+// Unlike grepFileJSON/grepFileContext (see scan.go), this intentionally
+// doesn't share the scanLines streaming scan: the JSON API groups context
+// symmetrically around each match into that match's own Before/After
+// slices, which needs the whole file's lines available for lookahead,
+// whereas scanLines emits a single interleaved stream sized for grep-style
+// CLI output. Unifying the two would mean flattening one shape into the
+// other for no benefit, so this stays a second, smaller scan rather than a
+// third reimplementation of scanLines' ring buffer.
+func grepFile(name string, re *regexp.Regexp, ctxLines int) ([]matchResult, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	var out []matchResult
+	for i, line := range lines {
+		col := re.MatchString(line, true, true)
+		if col < 0 {
+			continue
+		}
+		m := matchResult{Line: i + 1, Col: col, Text: line}
+		if ctxLines > 0 {
+			if lo := i - ctxLines; lo >= 0 {
+				m.Before = append([]string(nil), lines[lo:i]...)
+			} else {
+				m.Before = append([]string(nil), lines[0:i]...)
+			}
+			if hi := i + 1 + ctxLines; hi <= len(lines) {
+				m.After = append([]string(nil), lines[i+1:hi]...)
+			} else {
+				m.After = append([]string(nil), lines[i+1:len(lines)]...)
+			}
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// handleFile answers GET /file?name=path&hl=<re> by streaming the named
+// file, optionally marking which lines match hl via an X-Match-Lines header
+// so callers don't need a second round trip to highlight a result. name
+// must be a path the index actually knows about; this is what stops the
+// endpoint from being used to read arbitrary files reachable by the
+// daemon's process (e.g. ?name=/etc/passwd or a path-traversal name).
+func (s *server) handleFile(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("missing name parameter"))
+		return
+	}
+	s.reload()
+	if !s.isIndexed(name) {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("%s: not in index", name))
+		return
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	}
+	defer f.Close()
+
+	if hl := r.URL.Query().Get("hl"); hl != "" {
+		re, err := regexp.Compile("(?m)" + hl)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		matches, err := grepFile(name, re, 0)
+		if err == nil && len(matches) > 0 {
+			lineNos := make([]string, len(matches))
+			for i, m := range matches {
+				lineNos[i] = strconv.Itoa(m.Line)
+			}
+			w.Header().Set("X-Match-Lines", fmt.Sprintf("%v", lineNos))
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.Copy(w, f)
+}
+
+// Serve starts the HTTP daemon on addr, answering /search and /file against
+// the index at indexPath until the process is killed.
+func Serve(addr, indexPath string, queryTimeout time.Duration) error {
+	s := newServer(indexPath, queryTimeout)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/search", s.handleSearch)
+	mux.HandleFunc("/file", s.handleFile)
+	log.Printf("csearchd: listening on %s, index=%s", addr, indexPath)
+	return http.ListenAndServe(addr, mux)
+}
+
+// serveMain implements the "csearch serve" subcommand.
+func serveMain(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":6070", "address to serve HTTP on")
+	indexPath := fs.String("index", index.File(), "index file to serve")
+	queryTimeout := fs.Duration("query-timeout", 10*time.Second, "deadline for a single query")
+	fs.Usage = serveUsage
+	fs.Parse(args)
+
+	if err := Serve(*addr, *indexPath, *queryTimeout); err != nil {
+		log.Fatal(err)
+	}
+}
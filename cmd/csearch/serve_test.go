@@ -0,0 +1,135 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestServer builds a *server without going through newServer/reload, so
+// these tests don't need a real on-disk index.Index (this tree has no
+// cindex/index.Open fixture to build one from) — they exercise the request
+// validation and the indexed-name guard directly, which is where
+// handleSearch and handleFile actually make their decisions.
+func newTestServer(names map[string]bool) *server {
+	return &server{path: filepath.Join(os.TempDir(), "nonexistent.index"), names: names}
+}
+
+func TestHandleSearchMissingQ(t *testing.T) {
+	s := newTestServer(nil)
+	req := httptest.NewRequest(http.MethodGet, "/search", nil)
+	w := httptest.NewRecorder()
+	s.handleSearch(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHandleSearchBadLimit(t *testing.T) {
+	s := newTestServer(nil)
+	req := httptest.NewRequest(http.MethodGet, "/search?q=foo&limit=notanumber", nil)
+	w := httptest.NewRecorder()
+	s.handleSearch(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHandleSearchBadContext(t *testing.T) {
+	s := newTestServer(nil)
+	req := httptest.NewRequest(http.MethodGet, "/search?q=foo&context=notanumber", nil)
+	w := httptest.NewRecorder()
+	s.handleSearch(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHandleFileMissingName(t *testing.T) {
+	s := newTestServer(nil)
+	req := httptest.NewRequest(http.MethodGet, "/file", nil)
+	w := httptest.NewRecorder()
+	s.handleFile(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+// TestHandleFileRejectsUnindexedName is the regression test for the
+// path-traversal guard handleFile's doc comment describes: a name that
+// isn't in the index's own file list must 404, even if it exists on disk
+// (e.g. /etc/passwd, or a path-traversal name that happens to resolve).
+func TestHandleFileRejectsUnindexedName(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "secret.txt")
+	if err := os.WriteFile(name, []byte("top secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer(map[string]bool{}) // deliberately doesn't include name
+	req := httptest.NewRequest(http.MethodGet, "/file?name="+name, nil)
+	w := httptest.NewRecorder()
+	s.handleFile(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d for a name absent from the index", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestHandleFileServesIndexedName(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(name, []byte("hello world\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer(map[string]bool{name: true})
+	req := httptest.NewRequest(http.MethodGet, "/file?name="+name, nil)
+	w := httptest.NewRecorder()
+	s.handleFile(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := w.Body.String(); got != "hello world\n" {
+		t.Fatalf("body = %q, want %q", got, "hello world\n")
+	}
+}
+
+func TestHandleFileHighlightsMatchLines(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(name, []byte("one\nMATCH\nthree\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer(map[string]bool{name: true})
+	req := httptest.NewRequest(http.MethodGet, "/file?name="+name+"&hl=MATCH", nil)
+	w := httptest.NewRecorder()
+	s.handleFile(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get("X-Match-Lines"); got != "[2]" {
+		t.Fatalf("X-Match-Lines = %q, want %q", got, "[2]")
+	}
+}
+
+// TestReloadKeepsIndexWhenPathUnreadable checks reload's fallback path: if
+// the index file can't be stat'd (e.g. it was deleted out from under the
+// daemon), reload must return the currently loaded index rather than
+// discarding it, so an in-flight cindex run doesn't take the daemon down.
+func TestReloadKeepsIndexWhenPathUnreadable(t *testing.T) {
+	s := newTestServer(map[string]bool{"kept": true})
+	got := s.reload()
+	if got != nil {
+		t.Fatalf("reload() = %v, want nil (no index was ever opened)", got)
+	}
+	if !s.names["kept"] {
+		t.Fatalf("reload() cleared names after a failed stat, want it untouched")
+	}
+}
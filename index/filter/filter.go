@@ -0,0 +1,382 @@
+// Package filter implements a small boolean predicate language for
+// selecting files out of a csearch index by name, extension, size, or
+// language, e.g.:
+//
+//	path:~\.go$ AND NOT path:vendor/ AND (lang:go OR lang:proto) AND size:<1MB
+//
+// It is meant to replace csearch's repeated --allow-files (AND) /
+// --block-files (OR) regexp flags with a single expression, via the
+// --files flag.
+package filter
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"codesearch/regexp"
+)
+
+// FileMeta is the information about a candidate file that an Expr is
+// evaluated against.
+type FileMeta struct {
+	Name string // path as stored in the index
+
+	// Size returns the file's size in bytes, and whether it could be
+	// determined at all. It is a func rather than a plain int64 so that
+	// callers which don't build Size from an index-stored value (csearch's
+	// Main, today) can defer the os.Stat it requires until a size:
+	// predicate is actually evaluated, instead of paying that cost for
+	// every candidate file even when the expression never touches size:.
+	// Leave it nil if size is unknown or not applicable; sizeExpr then
+	// fails closed rather than treating the file as size 0.
+	Size func() (bytes int64, ok bool)
+}
+
+// Expr is a compiled predicate. Parse produces one from the filter
+// language; And, Or, and Not combine them programmatically, which is how
+// csearch folds its legacy --allow-files/--block-files flags into the
+// same evaluation path as --files.
+type Expr interface {
+	Match(m FileMeta) bool
+}
+
+type andExpr struct{ left, right Expr }
+
+func (e andExpr) Match(m FileMeta) bool { return e.left.Match(m) && e.right.Match(m) }
+
+type orExpr struct{ left, right Expr }
+
+func (e orExpr) Match(m FileMeta) bool { return e.left.Match(m) || e.right.Match(m) }
+
+type notExpr struct{ e Expr }
+
+func (e notExpr) Match(m FileMeta) bool { return !e.e.Match(m) }
+
+// And returns an Expr matching only when every one of exprs matches.
+func And(exprs ...Expr) Expr {
+	if len(exprs) == 0 {
+		return trueExpr{}
+	}
+	e := exprs[0]
+	for _, next := range exprs[1:] {
+		e = andExpr{e, next}
+	}
+	return e
+}
+
+// Or returns an Expr matching when any one of exprs matches.
+func Or(exprs ...Expr) Expr {
+	if len(exprs) == 0 {
+		return notExpr{trueExpr{}}
+	}
+	e := exprs[0]
+	for _, next := range exprs[1:] {
+		e = orExpr{e, next}
+	}
+	return e
+}
+
+// Not returns an Expr matching when e does not.
+func Not(e Expr) Expr { return notExpr{e} }
+
+type trueExpr struct{}
+
+func (trueExpr) Match(FileMeta) bool { return true }
+
+// PathRegexp returns an Expr matching files whose name matches re, the
+// same as an atom `path:~...` but taking an already-compiled regexp, for
+// callers (like csearch's --allow-files/--block-files) that compile their
+// own.
+func PathRegexp(re *regexp.Regexp) Expr {
+	return pathRegexpExpr{re}
+}
+
+type pathRegexpExpr struct{ re *regexp.Regexp }
+
+func (e pathRegexpExpr) Match(m FileMeta) bool {
+	return e.re.MatchString(m.Name, true, true) >= 0
+}
+
+type pathLiteralExpr struct{ lit string }
+
+func (e pathLiteralExpr) Match(m FileMeta) bool { return m.Name == e.lit }
+
+type pathContainsExpr struct{ substr string }
+
+func (e pathContainsExpr) Match(m FileMeta) bool { return strings.Contains(m.Name, e.substr) }
+
+type extExpr struct{ ext string }
+
+func (e extExpr) Match(m FileMeta) bool { return fileExt(m.Name) == e.ext }
+
+type langExpr struct{ lang string }
+
+func (e langExpr) Match(m FileMeta) bool { return extToLang[fileExt(m.Name)] == e.lang }
+
+type sizeExpr struct {
+	op    byte // '<' or '>'
+	bytes int64
+}
+
+func (e sizeExpr) Match(m FileMeta) bool {
+	if m.Size == nil {
+		return false
+	}
+	size, ok := m.Size()
+	if !ok {
+		// Unknown size (e.g. the file has since moved or been deleted)
+		// must not silently match size:<N the way a stray 0 would.
+		return false
+	}
+	if e.op == '<' {
+		return size < e.bytes
+	}
+	return size > e.bytes
+}
+
+func fileExt(name string) string {
+	return strings.TrimPrefix(filepath.Ext(name), ".")
+}
+
+// extToLang maps a file extension (without the leading dot) to the
+// language atom it corresponds to for `lang:X`.
+var extToLang = map[string]string{
+	"go":    "go",
+	"proto": "proto",
+	"py":    "python",
+	"js":    "javascript",
+	"jsx":   "javascript",
+	"ts":    "typescript",
+	"tsx":   "typescript",
+	"java":  "java",
+	"c":     "c",
+	"h":     "c",
+	"cc":    "cpp",
+	"cpp":   "cpp",
+	"hpp":   "cpp",
+	"rb":    "ruby",
+	"rs":    "rust",
+	"sh":    "shell",
+	"md":    "markdown",
+	"json":  "json",
+	"yaml":  "yaml",
+	"yml":   "yaml",
+}
+
+// Parse compiles a filter expression into an Expr.
+func Parse(expr string) (Expr, error) {
+	toks := tokenize(expr)
+	p := &parser{toks: toks}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.toks) {
+		return nil, fmt.Errorf("filter: unexpected token %q", p.toks[p.pos])
+	}
+	return e, nil
+}
+
+// tokenize splits expr into atom, "(", ")", "AND", "OR", and "NOT" tokens.
+// It first splits on whitespace, since that's the only thing separating two
+// adjacent atoms or keywords. Within a whitespace-delimited word, '(' and
+// ')' are only split off as their own boolean-grouping tokens when they
+// aren't balanced by a matching paren earlier in the same word: an atom's
+// own value (e.g. a path:~ regexp like `\.(go|proto)$`) commonly contains
+// balanced parens that must stay part of that atom, while something like
+// "(lang:go" or "proto)" has an unbalanced leading/trailing paren that is
+// actually boolean grouping glued onto the atom with no space.
+func tokenize(expr string) []string {
+	var toks []string
+	for _, word := range strings.Fields(expr) {
+		toks = append(toks, splitGroupingParens(word)...)
+	}
+	return toks
+}
+
+func splitGroupingParens(word string) []string {
+	var toks []string
+	var cur strings.Builder
+	depth := 0
+	flush := func() {
+		if cur.Len() > 0 {
+			toks = append(toks, cur.String())
+			cur.Reset()
+		}
+	}
+	for _, r := range word {
+		switch r {
+		case '(':
+			if cur.Len() == 0 {
+				// A leading '(' can't be part of an atom (every atom starts
+				// with key:value), so it's always boolean grouping.
+				toks = append(toks, "(")
+				continue
+			}
+			depth++
+			cur.WriteRune(r)
+		case ')':
+			if depth > 0 {
+				depth--
+				cur.WriteRune(r)
+			} else {
+				// Closes a boolean group, not a paren opened within this atom.
+				flush()
+				toks = append(toks, ")")
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	flush()
+	return toks
+}
+
+type parser struct {
+	toks []string
+	pos  int
+}
+
+func (p *parser) peek() string {
+	if p.pos >= len(p.toks) {
+		return ""
+	}
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "OR" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "AND" {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (Expr, error) {
+	if p.peek() == "NOT" {
+		p.next()
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{e}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	tok := p.next()
+	switch tok {
+	case "":
+		return nil, fmt.Errorf("filter: unexpected end of expression")
+	case "(":
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("filter: missing closing parenthesis")
+		}
+		return e, nil
+	default:
+		return parseAtom(tok)
+	}
+}
+
+func parseAtom(tok string) (Expr, error) {
+	key, val, ok := strings.Cut(tok, ":")
+	if !ok {
+		return nil, fmt.Errorf("filter: invalid predicate %q, want key:value", tok)
+	}
+	switch key {
+	case "path":
+		switch {
+		case strings.HasPrefix(val, "~"):
+			re, err := regexp.Compile(val[1:])
+			if err != nil {
+				return nil, fmt.Errorf("filter: bad path regexp %q: %v", val[1:], err)
+			}
+			return pathRegexpExpr{re}, nil
+		case strings.HasPrefix(val, "="):
+			return pathLiteralExpr{val[1:]}, nil
+		case val == "":
+			return nil, fmt.Errorf("filter: empty path predicate, want path:~RE, path:=LITERAL, or path:SUBSTRING")
+		default:
+			// No ~ or = prefix: a plain substring match, e.g. NOT path:vendor/
+			// to exclude any path containing "vendor/". This is the common
+			// case and what the package doc comment's own example uses.
+			return pathContainsExpr{val}, nil
+		}
+	case "ext":
+		return extExpr{val}, nil
+	case "lang":
+		return langExpr{val}, nil
+	case "size":
+		if len(val) == 0 || (val[0] != '<' && val[0] != '>') {
+			return nil, fmt.Errorf("filter: size predicate must start with < or >, got %q", val)
+		}
+		n, err := parseSize(val[1:])
+		if err != nil {
+			return nil, fmt.Errorf("filter: bad size %q: %v", val[1:], err)
+		}
+		return sizeExpr{op: val[0], bytes: n}, nil
+	default:
+		return nil, fmt.Errorf("filter: unknown predicate %q", key)
+	}
+}
+
+// parseSize parses a size like "1MB", "512KB", or "100" (bytes) into a
+// byte count, using 1024-based units.
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		factor int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	}
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			n, err := strconv.ParseInt(strings.TrimSuffix(s, u.suffix), 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return n * u.factor, nil
+		}
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
@@ -0,0 +1,104 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package filter
+
+import "testing"
+
+func known(n int64) func() (int64, bool) { return func() (int64, bool) { return n, true } }
+func unknown() (int64, bool)             { return 0, false }
+
+func TestParseAndMatch(t *testing.T) {
+	cases := []struct {
+		expr string
+		meta FileMeta
+		want bool
+	}{
+		{`path:~\.go$`, FileMeta{Name: "main.go"}, true},
+		{`path:~\.go$`, FileMeta{Name: "main.py"}, false},
+		{`path:=main.go`, FileMeta{Name: "main.go"}, true},
+		{`path:=main.go`, FileMeta{Name: "other.go"}, false},
+		{`ext:go`, FileMeta{Name: "main.go"}, true},
+		{`lang:go`, FileMeta{Name: "main.go"}, true},
+		{`lang:python`, FileMeta{Name: "main.go"}, false},
+		{`size:<1KB`, FileMeta{Name: "f", Size: known(10)}, true},
+		{`size:<1KB`, FileMeta{Name: "f", Size: known(2000)}, false},
+		{`size:>1MB`, FileMeta{Name: "f", Size: known(2 << 20)}, true},
+		{`NOT path:vendor/`, FileMeta{Name: "main.go"}, true},
+		{`NOT path:vendor/`, FileMeta{Name: "vendor/main.go"}, false},
+		{`path:vendor/`, FileMeta{Name: "a/vendor/b.go"}, true},
+		{`path:~\.go$ AND NOT path:vendor/`, FileMeta{Name: "vendor/main.go"}, false},
+		{`lang:go OR lang:proto`, FileMeta{Name: "api.proto"}, true},
+		{`(lang:go OR lang:proto) AND size:<1MB`, FileMeta{Name: "api.proto", Size: known(10)}, true},
+		{`path:~\.(go|proto)$`, FileMeta{Name: "api.proto"}, true},
+		{`path:~\.(go|proto)$`, FileMeta{Name: "api.py"}, false},
+		{`(path:~\.(go|proto)$) AND ext:go`, FileMeta{Name: "main.go"}, true},
+	}
+	for _, c := range cases {
+		e, err := Parse(c.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", c.expr, err)
+		}
+		if got := e.Match(c.meta); got != c.want {
+			t.Errorf("Parse(%q).Match(%+v) = %v, want %v", c.expr, c.meta, got, c.want)
+		}
+	}
+}
+
+func TestSizePredicateFailsClosedOnUnknownSize(t *testing.T) {
+	e, err := Parse("size:<1MB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := FileMeta{Name: "f", Size: unknown}
+	if e.Match(meta) {
+		t.Fatalf("size:<1MB matched a file with unknown size; want fail-closed (no match)")
+	}
+
+	meta = FileMeta{Name: "f"} // Size left nil
+	if e.Match(meta) {
+		t.Fatalf("size:<1MB matched a FileMeta with nil Size; want fail-closed (no match)")
+	}
+}
+
+func TestTokenizeParensInsideAtomValue(t *testing.T) {
+	cases := []struct {
+		expr string
+		want []string
+	}{
+		{`path:~\.go$`, []string{`path:~\.go$`}},
+		{`path:~\.(go|proto)$`, []string{`path:~\.(go|proto)$`}},
+		{`(lang:go OR lang:proto)`, []string{"(", "lang:go", "OR", "lang:proto", ")"}},
+		{`(path:~\.(go|proto)$) AND ext:go`, []string{"(", `path:~\.(go|proto)$`, ")", "AND", "ext:go"}},
+	}
+	for _, c := range cases {
+		got := tokenize(c.expr)
+		if len(got) != len(c.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", c.expr, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("tokenize(%q)[%d] = %q, want %q", c.expr, i, got[i], c.want[i])
+			}
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"bogus",
+		"path:",
+		"path:~(unbalanced",
+		"size:1KB",
+		"size:<notanumber",
+		"(path:=a",
+		"path:=a)",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q): got nil error, want error", expr)
+		}
+	}
+}
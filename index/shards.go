@@ -0,0 +1,169 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// shardBits is the number of low bits of a fileid reserved for the local,
+// per-shard file number. The remaining high bits select the shard. This
+// keeps Shards.PostingQuery returning the same []uint32 type that callers
+// already pass to Index.Name, so Main() doesn't need a second code path
+// for the single-shard case.
+const shardBits = 24
+
+// maxShards and maxShardFiles are the limits implied by shardBits: up to
+// 256 shards of up to 16M files each, which comfortably covers splitting a
+// monorepo into per-subtree shards.
+const (
+	maxShards     = 1 << (32 - shardBits)
+	maxShardFiles = 1 << shardBits
+)
+
+// Shards fans PostingQuery out across several independently built index
+// files and presents them as a single virtual index, the way cindex-ing a
+// monorepo into per-subtree shards that can be rebuilt independently would
+// want to be searched as one.
+type Shards struct {
+	shards []*Index
+}
+
+// OpenShards opens the index at each of paths and returns a Shards wrapping
+// all of them. It corresponds to Open, generalized to more than one
+// index file.
+//
+// Each shard's NumFiles is checked against maxShardFiles here, once, at
+// open time, but only when there's more than one shard to pack fileids
+// for: a single index opened through the unchanged $CSEARCHINDEX/--index
+// path never goes through encodeFileID's shard-bit packing, so it has no
+// reason to share that bound and an existing large single index shouldn't
+// start failing to open just because this package now also supports
+// sharding. With more than one shard, the bound must hold for every fileid
+// a shard could ever produce, not just those in a particular query's
+// result: checking the length of a PostingQuery result instead (as an
+// earlier version did) lets a shard with more than maxShardFiles files but
+// a small result set slip through, overflowing the shard-index bits in
+// encodeFileID and corrupting the encoding silently.
+func OpenShards(paths []string) *Shards {
+	if len(paths) > maxShards {
+		log.Fatalf("index: too many shards: %d (max %d)", len(paths), maxShards)
+	}
+	s := &Shards{
+		shards: make([]*Index, len(paths)),
+	}
+	for i, path := range paths {
+		ix := Open(path)
+		if len(paths) > 1 {
+			if n := ix.NumFiles(); n > maxShardFiles {
+				log.Fatalf("index: shard %s has too many files: %d (max %d)", path, n, maxShardFiles)
+			}
+		}
+		s.shards[i] = ix
+	}
+	return s
+}
+
+// SetVerbose sets the Verbose flag on every shard.
+func (s *Shards) SetVerbose(v bool) {
+	for _, ix := range s.shards {
+		ix.Verbose = v
+	}
+}
+
+// PostingQuery runs q against every shard and merges the results, encoding
+// each shard-local fileid with its shard index in the high bits so the
+// combined list can still be passed to Name. The per-shard file count was
+// already validated to fit in shardBits by OpenShards, so every local id
+// returned here is safe to encode. With a single shard the ids are returned
+// unencoded, since that shard's file count was not checked.
+func (s *Shards) PostingQuery(q *Query) []uint32 {
+	if len(s.shards) == 1 {
+		return s.shards[0].PostingQuery(q)
+	}
+	var merged []uint32
+	for i, ix := range s.shards {
+		local := ix.PostingQuery(q)
+		for _, id := range local {
+			merged = append(merged, encodeFileID(uint32(i), id))
+		}
+	}
+	return merged
+}
+
+// Name returns the file name for a fileid produced by PostingQuery.
+func (s *Shards) Name(globalID uint32) string {
+	if len(s.shards) == 1 {
+		return s.shards[0].Name(globalID)
+	}
+	shard, local := decodeFileID(globalID)
+	return s.shards[shard].Name(local)
+}
+
+// encodeFileID packs a shard index and a shard-local fileid into the
+// single uint32 fileid space callers of PostingQuery/Name see. Callers
+// must ensure local < maxShardFiles (OpenShards enforces this for every
+// id a shard can produce) and shard < maxShards, or the two fields will
+// overlap and corrupt each other.
+func encodeFileID(shard, local uint32) uint32 {
+	return shard<<shardBits | local
+}
+
+// decodeFileID is the inverse of encodeFileID.
+func decodeFileID(id uint32) (shard, local uint32) {
+	return id >> shardBits, id & (maxShardFiles - 1)
+}
+
+// ShardManifest lists the index files making up a virtual multi-shard
+// index, along with the root each shard was built from, so tooling can
+// discover and rebuild individual shards without re-cindexing the whole
+// tree. cmd/csearch's openShards reads one with ReadManifest and opens the
+// listed shards itself, since a single colon-separated --index/$CSEARCHINDEX
+// list can mix manifests and raw index files that all need merging into one
+// Shards; nothing in this tree writes a manifest yet (that's a cindex
+// feature this repo doesn't have), but WriteManifest is the format any
+// future writer should produce.
+type ShardManifest struct {
+	Shards []ShardManifestEntry `json:"shards"`
+}
+
+// ShardManifestEntry is one member of a ShardManifest.
+type ShardManifestEntry struct {
+	Path string `json:"path"` // path to the shard's index file
+	Root string `json:"root"` // path the shard was cindexed from
+}
+
+// WriteManifest writes a shard manifest listing entries to path.
+func WriteManifest(path string, entries []ShardManifestEntry) error {
+	data, err := json.MarshalIndent(ShardManifest{Shards: entries}, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// ReadManifest reads a shard manifest previously written by WriteManifest.
+func ReadManifest(path string) ([]ShardManifestEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m ShardManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parsing shard manifest %s: %v", path, err)
+	}
+	return m.Shards, nil
+}
+
+// IsManifest reports whether path looks like a shard manifest rather than
+// a raw index file, based on the .manifest suffix used by WriteManifest's
+// callers.
+func IsManifest(path string) bool {
+	return strings.HasSuffix(path, ".manifest")
+}
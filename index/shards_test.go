@@ -0,0 +1,67 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestEncodeDecodeFileID(t *testing.T) {
+	cases := []struct {
+		shard, local uint32
+	}{
+		{0, 0},
+		{0, maxShardFiles - 1},
+		{1, 0},
+		{maxShards - 1, maxShardFiles - 1},
+		{3, 17000000 % maxShardFiles}, // a large local id, as chunk0-3's review noted
+	}
+	for _, c := range cases {
+		id := encodeFileID(c.shard, c.local)
+		shard, local := decodeFileID(id)
+		if shard != c.shard || local != c.local {
+			t.Errorf("encodeFileID(%d, %d) = %d, decodeFileID(%d) = (%d, %d); want (%d, %d)",
+				c.shard, c.local, id, id, shard, local, c.shard, c.local)
+		}
+	}
+}
+
+func TestEncodeFileIDDoesNotOverlapShards(t *testing.T) {
+	// A local id right at the maxShardFiles boundary must not spill into
+	// the next shard's bits.
+	low := encodeFileID(0, maxShardFiles-1)
+	high := encodeFileID(1, 0)
+	if low >= high {
+		t.Fatalf("encodeFileID(0, maxShardFiles-1) = %d >= encodeFileID(1, 0) = %d; shards overlap", low, high)
+	}
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	want := []ShardManifestEntry{
+		{Path: "shard0.index", Root: "/repo/a"},
+		{Path: "shard1.index", Root: "/repo/b"},
+	}
+	path := filepath.Join(t.TempDir(), "shards.manifest")
+	if err := WriteManifest(path, want); err != nil {
+		t.Fatalf("WriteManifest: %v", err)
+	}
+	if !IsManifest(path) {
+		t.Fatalf("IsManifest(%q) = false, want true", path)
+	}
+
+	got, err := ReadManifest(path)
+	if err != nil {
+		t.Fatalf("ReadManifest: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ReadManifest returned %d entries, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}